@@ -0,0 +1,140 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// This file adds preset-dictionary support: seeding the 64KB match window
+// with bytes the caller already knows both sides share, so short, mutually
+// similar payloads (RPC frames, log lines, database rows) can reference
+// repetition that wouldn't otherwise fit inside a single small message.
+
+package lz4
+
+// maxDictWindow is the largest dictionary suffix that can ever be
+// referenced, matching the 16-bit back-reference distance of the block
+// format.
+const maxDictWindow = 64 << 10
+
+// Dictionary is a preset dictionary shared by an encoder and decoder. Only
+// its last 64KB are ever used, since that is as far back as an LZ4 block
+// can reference.
+type Dictionary struct {
+	data []byte
+	id   uint32
+}
+
+// NewDictionary builds a Dictionary from raw bytes. raw is trimmed down to
+// its last 64KB, since that is the most a block can ever reference.
+func NewDictionary(raw []byte) *Dictionary {
+	if len(raw) > maxDictWindow {
+		raw = raw[len(raw)-maxDictWindow:]
+	}
+	return &Dictionary{data: raw, id: xxh32Checksum(0, raw)}
+}
+
+// ID returns the xxHash32 of the dictionary bytes, written into a frame's
+// dictID field so a decoder can detect it was handed the wrong dictionary.
+func (d *Dictionary) ID() uint32 {
+	return d.id
+}
+
+func seedHashTable(hashTable []uint32, data []byte) {
+	for pos := 0; pos+minMatch <= len(data); pos++ {
+		sequence := uint32(data[pos+3])<<24 | uint32(data[pos+2])<<16 | uint32(data[pos+1])<<8 | uint32(data[pos])
+		hash := (sequence * 2654435761) >> hashShift
+		hashTable[hash] = uint32(pos) - uninitHash
+	}
+}
+
+// EncodeWithDict compresses src into dst exactly as Encode does, except the
+// match window is seeded with dict first, so src may back-reference
+// repetition found in the dictionary. The resulting block is decodable by
+// DecodeWithDict given the same dictionary - or by Decode, if it happens
+// not to reference the dictionary at all.
+func EncodeWithDict(dst, src []byte, dict *Dictionary) ([]byte, error) {
+
+	if len(src) >= MaxInputSize {
+		return nil, ErrTooLarge
+	}
+
+	if dict == nil || len(dict.data) == 0 {
+		return Encode(dst, src)
+	}
+
+	if n := CompressBound(len(src)); len(dst) < n {
+		dst = make([]byte, n)
+	}
+
+	combined := make([]byte, len(dict.data)+len(src))
+	copy(combined, dict.data)
+	copy(combined[len(dict.data):], src)
+
+	hashTable := make([]uint32, hashTableSize)
+	seedHashTable(hashTable, dict.data)
+
+	compressed := encodeBlockFrom(dst, combined, hashTable, uint32(len(dict.data)))
+	return compressed, nil
+}
+
+// DecodeWithDict decompresses a block produced by EncodeWithDict (or, for
+// that matter, Encode) using the same dictionary, returning the slice of
+// dst holding the decompressed data.
+func DecodeWithDict(dst, src []byte, dict *Dictionary) ([]byte, error) {
+
+	if dict == nil || len(dict.data) == 0 {
+		return Decode(dst, src)
+	}
+
+	need := len(dict.data)
+	if cap(dst) > len(dst) {
+		dst = dst[:cap(dst)]
+	}
+	if len(dst) < need {
+		dst = make([]byte, need+need/2+64)
+	}
+	copy(dst, dict.data)
+
+	out, err := decodeBlock(dst, uint32(len(dict.data)), src)
+	if err != nil {
+		return nil, err
+	}
+
+	return out[len(dict.data):], nil
+}
+
+// SetDictionary seeds fw's match window with dict and writes dict's ID into
+// the frame descriptor's dictID field. It must be called before the first
+// Write or Close.
+func (f *FrameWriter) SetDictionary(dict *Dictionary) {
+	f.dict = dict
+	if dict != nil {
+		f.desc.DictID = dict.ID()
+	}
+}
+
+// SetDictionary tells fr to seed its match window with dict when
+// decompressing blocks. It must be called before the first Read, and
+// should match whatever dictionary (if any) the writer used.
+func (f *FrameReader) SetDictionary(dict *Dictionary) {
+	f.dict = dict
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lz4
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestDictRoundTrip(t *testing.T) {
+	dict := NewDictionary([]byte("the quick brown fox jumps over the lazy dog. "))
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"references-dict", []byte("the quick brown fox jumps over the lazy dog. again!")},
+		{"unrelated", []byte("completely different content with no overlap")},
+		{"empty", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			compressed, err := EncodeWithDict(nil, c.data, dict)
+			if err != nil {
+				t.Fatalf("EncodeWithDict: %v", err)
+			}
+			out, err := DecodeWithDict(nil, compressed, dict)
+			if err != nil {
+				t.Fatalf("DecodeWithDict: %v", err)
+			}
+			if !bytes.Equal(out, c.data) {
+				t.Fatalf("roundtrip mismatch: got %q, want %q", out, c.data)
+			}
+		})
+	}
+}
+
+// TestDictNoDictFallsBackToPlain checks that a nil or empty dictionary
+// behaves exactly like Encode/Decode.
+func TestDictNoDictFallsBackToPlain(t *testing.T) {
+	data := []byte("some data with no dictionary involved")
+
+	compressed, err := EncodeWithDict(nil, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(nil, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("got %q, want %q", out, data)
+	}
+
+	empty := NewDictionary(nil)
+	compressed, err = EncodeWithDict(nil, data, empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = DecodeWithDict(nil, compressed, empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("got %q, want %q", out, data)
+	}
+}
+
+// TestDecodeWithDictReusesDst checks that DecodeWithDict honors the same
+// buffer-reuse contract as Decode/EncodeWithDict: when dst already has
+// enough capacity, the returned slice must be backed by dst's own array
+// rather than a freshly allocated one.
+func TestDecodeWithDictReusesDst(t *testing.T) {
+	dict := NewDictionary([]byte("the quick brown fox jumps over the lazy dog. "))
+	data := []byte("the quick brown fox jumps over the lazy dog. again!")
+
+	compressed, err := EncodeWithDict(nil, data, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backing := make([]byte, len(dict.data)+len(data)+64)
+	dst := backing[:0]
+	out, err := DecodeWithDict(dst, compressed, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("got %q, want %q", out, data)
+	}
+	if &out[0] != &backing[len(dict.data)] {
+		t.Fatal("DecodeWithDict did not reuse dst's backing array")
+	}
+}
+
+// TestDictWindowIsTrimmed checks that NewDictionary keeps only the last
+// maxDictWindow bytes, since that is as far back as a block can ever
+// reference - a larger dictionary would otherwise just be dead weight
+// copied into every encode/decode buffer.
+func TestDictWindowIsTrimmed(t *testing.T) {
+	raw := make([]byte, maxDictWindow+1000)
+	rand.New(rand.NewSource(4)).Read(raw)
+
+	dict := NewDictionary(raw)
+	if len(dict.data) != maxDictWindow {
+		t.Fatalf("dict.data len = %d, want %d", len(dict.data), maxDictWindow)
+	}
+	if !bytes.Equal(dict.data, raw[len(raw)-maxDictWindow:]) {
+		t.Fatal("dict.data is not the trailing maxDictWindow bytes of raw")
+	}
+}
+
+// TestDictIDDistinguishesContent checks that two dictionaries with
+// different content never collide on ID, the property FrameReader relies
+// on to reject a mismatched dictionary via ErrDictionaryMismatch.
+func TestDictIDDistinguishesContent(t *testing.T) {
+	a := NewDictionary([]byte("dictionary one"))
+	b := NewDictionary([]byte("dictionary two"))
+	if a.ID() == b.ID() {
+		t.Fatal("distinct dictionaries produced the same ID")
+	}
+}
+
+// TestFrameDictRoundTrip exercises SetDictionary end to end through
+// FrameWriter/FrameReader, including the ErrDictionaryMismatch path when
+// the reader is handed the wrong dictionary.
+func TestFrameDictRoundTrip(t *testing.T) {
+	dict := NewDictionary([]byte("the quick brown fox jumps over the lazy dog. "))
+	data := []byte("the quick brown fox jumps over the lazy dog. again and again!")
+
+	var buf bytes.Buffer
+	fw := NewFrameWriterDescriptor(&buf, FrameDescriptor{})
+	fw.SetDictionary(dict)
+	if _, err := fw.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	framed := buf.Bytes()
+
+	fr := NewFrameReader(bytes.NewReader(framed))
+	fr.SetDictionary(dict)
+	out, err := io.ReadAll(fr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("roundtrip mismatch: got %q, want %q", out, data)
+	}
+
+	wrong := NewDictionary([]byte("a completely different dictionary"))
+	fr = NewFrameReader(bytes.NewReader(framed))
+	fr.SetDictionary(wrong)
+	if _, err := io.ReadAll(fr); err != ErrDictionaryMismatch {
+		t.Fatalf("got err %v, want ErrDictionaryMismatch", err)
+	}
+}
@@ -0,0 +1,519 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// This file implements the LZ4 Frame format (as produced by the reference
+// `lz4` command line tool), layered on top of the raw block codec in
+// reader.go/writer.go. It is a self-contained framing: magic number, frame
+// descriptor, a sequence of independently-sized blocks and an end mark.
+
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const frameMagic uint32 = 0x184D2204
+
+// frameVersion is the only version defined by the LZ4 frame specification.
+const frameVersion = 1
+
+// Block maximum sizes, as encoded in bits 4-6 of the frame descriptor's BD byte.
+const (
+	Block64KB  = 64 << 10
+	Block256KB = 256 << 10
+	Block1MB   = 1 << 20
+	Block4MB   = 4 << 20
+)
+
+const blockUncompressedFlag uint32 = 1 << 31
+
+var (
+	// ErrInvalidFrame is returned when the magic number does not match the
+	// LZ4 frame magic.
+	ErrInvalidFrame = errors.New("lz4: invalid frame magic number")
+
+	// ErrHeaderChecksum is returned when the frame descriptor's checksum
+	// byte does not match the computed xxHash32.
+	ErrHeaderChecksum = errors.New("lz4: frame header checksum mismatch")
+
+	// ErrBlockChecksum is returned when a block's xxHash32 does not match
+	// the one stored alongside it.
+	ErrBlockChecksum = errors.New("lz4: block checksum mismatch")
+
+	// ErrContentChecksum is returned when the content xxHash32 at the end
+	// of the frame does not match the decompressed content.
+	ErrContentChecksum = errors.New("lz4: content checksum mismatch")
+
+	// ErrUnsupportedBlockSize is returned for a BD byte that does not
+	// encode one of the four standard block sizes.
+	ErrUnsupportedBlockSize = errors.New("lz4: unsupported block max size")
+
+	// ErrDictionaryMismatch is returned when a FrameReader has a
+	// dictionary set via SetDictionary whose ID does not match the
+	// frame's dictID field.
+	ErrDictionaryMismatch = errors.New("lz4: dictionary mismatch")
+)
+
+// FrameDescriptor carries the options encoded into a frame header. The zero
+// value describes a frame with block-independence on, no checksums and a
+// 64KB block size, matching the common defaults used by the lz4 CLI.
+type FrameDescriptor struct {
+	// BlockIndependence is accepted for parity with the frame format, but
+	// FrameWriter always compresses each block independently (never
+	// referencing a previous block's window), so the header bit it writes
+	// is always 1 regardless of what this field is set to. A FrameReader
+	// reports whatever the header actually says here, but it decodes every
+	// block on its own, without the previous block's window, so a frame
+	// whose blocks reference earlier blocks fails to decode.
+	BlockIndependence bool
+	BlockChecksum     bool
+	ContentChecksum   bool
+	ContentSize       uint64 // 0 means the size is omitted from the header
+	BlockMaxSize      int    // one of Block64KB, Block256KB, Block1MB, Block4MB; 0 defaults to Block64KB
+	DictID            uint32 // 0 means no dictionary ID is written; see Dictionary.ID
+}
+
+func blockSizeID(size int) (byte, error) {
+	switch size {
+	case 0, Block64KB:
+		return 4, nil
+	case Block256KB:
+		return 5, nil
+	case Block1MB:
+		return 6, nil
+	case Block4MB:
+		return 7, nil
+	default:
+		return 0, ErrUnsupportedBlockSize
+	}
+}
+
+func blockSizeFromID(id byte) (int, error) {
+	switch id {
+	case 4:
+		return Block64KB, nil
+	case 5:
+		return Block256KB, nil
+	case 6:
+		return Block1MB, nil
+	case 7:
+		return Block4MB, nil
+	default:
+		return 0, ErrUnsupportedBlockSize
+	}
+}
+
+// FrameWriter compresses data written to it into the LZ4 frame format and
+// writes the result to the underlying io.Writer.
+type FrameWriter struct {
+	w           io.Writer
+	desc        FrameDescriptor
+	blockSize   int
+	buf         []byte
+	pos         int
+	contentHash *xxh32State
+	wroteHeader bool
+	closed      bool
+	dict        *Dictionary
+}
+
+// NewFrameWriter returns a FrameWriter with the default frame descriptor:
+// block-independent, 64KB blocks, no checksums.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return NewFrameWriterDescriptor(w, FrameDescriptor{})
+}
+
+// NewFrameWriterDescriptor returns a FrameWriter that frames its output
+// according to desc.
+func NewFrameWriterDescriptor(w io.Writer, desc FrameDescriptor) *FrameWriter {
+	blockSize := desc.BlockMaxSize
+	if blockSize == 0 {
+		blockSize = Block64KB
+	}
+	return &FrameWriter{
+		w:           w,
+		desc:        desc,
+		blockSize:   blockSize,
+		buf:         make([]byte, blockSize),
+		contentHash: newXxh32State(0),
+	}
+}
+
+func (f *FrameWriter) writeHeader() error {
+
+	bsID, err := blockSizeID(f.blockSize)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, 15)
+
+	flg := byte(frameVersion) << 6
+	// Every block FrameWriter produces is independently compressed, so the
+	// header always advertises independence - see the BlockIndependence
+	// doc comment on FrameDescriptor.
+	flg |= 1 << 5
+	if f.desc.BlockChecksum {
+		flg |= 1 << 4
+	}
+	if f.desc.ContentSize != 0 {
+		flg |= 1 << 3
+	}
+	if f.desc.ContentChecksum {
+		flg |= 1 << 2
+	}
+	if f.desc.DictID != 0 {
+		flg |= 1 << 0
+	}
+
+	bd := bsID << 4
+
+	header = append(header, flg, bd)
+	if f.desc.ContentSize != 0 {
+		var sz [8]byte
+		binary.LittleEndian.PutUint64(sz[:], f.desc.ContentSize)
+		header = append(header, sz[:]...)
+	}
+	if f.desc.DictID != 0 {
+		var id [4]byte
+		binary.LittleEndian.PutUint32(id[:], f.desc.DictID)
+		header = append(header, id[:]...)
+	}
+
+	hc := byte(xxh32Checksum(0, header) >> 8)
+
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], frameMagic)
+
+	if _, err := f.w.Write(magic[:]); err != nil {
+		return err
+	}
+	if _, err := f.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := f.w.Write([]byte{hc}); err != nil {
+		return err
+	}
+
+	f.wroteHeader = true
+	return nil
+}
+
+func (f *FrameWriter) writeBlock(block []byte) error {
+
+	dst := make([]byte, CompressBound(len(block)))
+	compressed, err := EncodeWithDict(dst, block, f.dict)
+	if err != nil {
+		return err
+	}
+
+	if len(compressed) >= len(block) {
+		return f.writeRawBlock(block, true)
+	}
+	return f.writeRawBlock(compressed, false)
+}
+
+// writeRawBlock writes a block that has already been compressed (or chosen
+// to be stored raw) by the caller. writeBlock uses it, and so does
+// ParallelEncoder to write the blocks its worker pool compressed.
+func (f *FrameWriter) writeRawBlock(payload []byte, uncompressed bool) error {
+
+	sizeField := uint32(len(payload))
+	if uncompressed {
+		sizeField |= blockUncompressedFlag
+	}
+
+	var hdr [4]byte
+	binary.LittleEndian.PutUint32(hdr[:], sizeField)
+	if _, err := f.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := f.w.Write(payload); err != nil {
+		return err
+	}
+
+	if f.desc.BlockChecksum {
+		var sum [4]byte
+		binary.LittleEndian.PutUint32(sum[:], xxh32Checksum(0, payload))
+		if _, err := f.w.Write(sum[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write implements io.Writer, buffering p into block-sized chunks and
+// flushing completed blocks to the underlying writer.
+func (f *FrameWriter) Write(p []byte) (int, error) {
+
+	if !f.wroteHeader {
+		if err := f.writeHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.desc.ContentChecksum {
+		f.contentHash.Write(p)
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(f.buf[f.pos:], p)
+		f.pos += n
+		p = p[n:]
+		written += n
+
+		if f.pos == len(f.buf) {
+			if err := f.writeBlock(f.buf[:f.pos]); err != nil {
+				return written, err
+			}
+			f.pos = 0
+		}
+	}
+
+	return written, nil
+}
+
+// Close flushes any buffered bytes as a final block, writes the end mark
+// and, if enabled, the content checksum. It does not close the underlying
+// writer.
+func (f *FrameWriter) Close() error {
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	if !f.wroteHeader {
+		if err := f.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	if f.pos > 0 {
+		if err := f.writeBlock(f.buf[:f.pos]); err != nil {
+			return err
+		}
+		f.pos = 0
+	}
+
+	var end [4]byte
+	if _, err := f.w.Write(end[:]); err != nil {
+		return err
+	}
+
+	if f.desc.ContentChecksum {
+		var sum [4]byte
+		binary.LittleEndian.PutUint32(sum[:], f.contentHash.Sum32())
+		if _, err := f.w.Write(sum[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FrameReader decompresses an LZ4 frame read from the underlying io.Reader.
+type FrameReader struct {
+	r           io.Reader
+	desc        FrameDescriptor
+	blockSize   int
+	readHeader  bool
+	done        bool
+	contentHash *xxh32State
+	out         []byte
+	outPos      int
+	dict        *Dictionary
+}
+
+// NewFrameReader returns a FrameReader reading the LZ4 frame in r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r, contentHash: newXxh32State(0)}
+}
+
+// Descriptor returns the frame descriptor, valid only after the header has
+// been read (i.e. after the first successful Read).
+func (f *FrameReader) Descriptor() FrameDescriptor {
+	return f.desc
+}
+
+func (f *FrameReader) readFull(buf []byte) error {
+	_, err := io.ReadFull(f.r, buf)
+	return err
+}
+
+func (f *FrameReader) readHeaderOnce() error {
+
+	if f.readHeader {
+		return nil
+	}
+
+	var magic [4]byte
+	if err := f.readFull(magic[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(magic[:]) != frameMagic {
+		return ErrInvalidFrame
+	}
+
+	var flgBd [2]byte
+	if err := f.readFull(flgBd[:]); err != nil {
+		return err
+	}
+	header := append([]byte{}, flgBd[:]...)
+
+	flg := flgBd[0]
+	bd := flgBd[1]
+
+	f.desc.BlockIndependence = flg&(1<<5) != 0
+	f.desc.BlockChecksum = flg&(1<<4) != 0
+	f.desc.ContentChecksum = flg&(1<<2) != 0
+	hasContentSize := flg&(1<<3) != 0
+	hasDictID := flg&(1<<0) != 0
+
+	blockSize, err := blockSizeFromID((bd >> 4) & 0x7)
+	if err != nil {
+		return err
+	}
+	f.desc.BlockMaxSize = blockSize
+	f.blockSize = blockSize
+
+	if hasContentSize {
+		var sz [8]byte
+		if err := f.readFull(sz[:]); err != nil {
+			return err
+		}
+		header = append(header, sz[:]...)
+		f.desc.ContentSize = binary.LittleEndian.Uint64(sz[:])
+	}
+
+	if hasDictID {
+		var id [4]byte
+		if err := f.readFull(id[:]); err != nil {
+			return err
+		}
+		header = append(header, id[:]...)
+		f.desc.DictID = binary.LittleEndian.Uint32(id[:])
+	}
+
+	var hc [1]byte
+	if err := f.readFull(hc[:]); err != nil {
+		return err
+	}
+	if hc[0] != byte(xxh32Checksum(0, header)>>8) {
+		return ErrHeaderChecksum
+	}
+
+	if f.dict != nil && f.desc.DictID != 0 && f.dict.ID() != f.desc.DictID {
+		return ErrDictionaryMismatch
+	}
+
+	f.readHeader = true
+	return nil
+}
+
+func (f *FrameReader) readBlock() error {
+
+	var sizeField [4]byte
+	if err := f.readFull(sizeField[:]); err != nil {
+		return err
+	}
+
+	size := binary.LittleEndian.Uint32(sizeField[:])
+	if size == 0 {
+		f.done = true
+		if f.desc.ContentChecksum {
+			var sum [4]byte
+			if err := f.readFull(sum[:]); err != nil {
+				return err
+			}
+			if binary.LittleEndian.Uint32(sum[:]) != f.contentHash.Sum32() {
+				return ErrContentChecksum
+			}
+		}
+		return io.EOF
+	}
+
+	uncompressed := size&blockUncompressedFlag != 0
+	size &^= blockUncompressedFlag
+
+	payload := make([]byte, size)
+	if err := f.readFull(payload); err != nil {
+		return err
+	}
+
+	if f.desc.BlockChecksum {
+		var sum [4]byte
+		if err := f.readFull(sum[:]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != xxh32Checksum(0, payload) {
+			return ErrBlockChecksum
+		}
+	}
+
+	if uncompressed {
+		f.out = payload
+	} else {
+		dst := make([]byte, f.blockSize)
+		decoded, err := DecodeWithDict(dst, payload, f.dict)
+		if err != nil {
+			return err
+		}
+		f.out = decoded
+	}
+	f.outPos = 0
+
+	if f.desc.ContentChecksum {
+		f.contentHash.Write(f.out)
+	}
+
+	return nil
+}
+
+// Read implements io.Reader, decompressing blocks on demand.
+func (f *FrameReader) Read(p []byte) (int, error) {
+
+	if f.done {
+		return 0, io.EOF
+	}
+
+	if err := f.readHeaderOnce(); err != nil {
+		return 0, err
+	}
+
+	for f.outPos >= len(f.out) {
+		if err := f.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, f.out[f.outPos:])
+	f.outPos += n
+	return n, nil
+}
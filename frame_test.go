@@ -0,0 +1,170 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lz4
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func frameRoundTrip(t *testing.T, desc FrameDescriptor, data []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	fw := NewFrameWriterDescriptor(&buf, desc)
+	if _, err := fw.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	fr := NewFrameReader(&buf)
+	out, err := io.ReadAll(fr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("roundtrip mismatch: got %d bytes, want %d", len(out), len(data))
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	compressible := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 2000)
+	random := make([]byte, 20000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	cases := []struct {
+		name string
+		desc FrameDescriptor
+		data []byte
+	}{
+		{"defaults/compressible", FrameDescriptor{}, compressible},
+		{"defaults/random", FrameDescriptor{}, random},
+		{"defaults/empty", FrameDescriptor{}, nil},
+		{"checksums", FrameDescriptor{BlockChecksum: true, ContentChecksum: true}, compressible},
+		{"content-size", FrameDescriptor{ContentSize: uint64(len(compressible))}, compressible},
+		{"small-blocks", FrameDescriptor{BlockMaxSize: Block64KB}, bytes.Repeat(compressible, 3)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			frameRoundTrip(t, c.desc, c.data)
+		})
+	}
+}
+
+// TestFrameEndsOnMatch exercises the case that broke interop with the
+// reference decoder: a block whose final bytes are part of a match rather
+// than a trailing literal run (see lastLiterals in writer.go).
+func TestFrameEndsOnMatch(t *testing.T) {
+	frameRoundTrip(t, FrameDescriptor{}, bytes.Repeat([]byte("AB"), 1000))
+}
+
+// TestFrameCLIInterop checks that frames written by FrameWriter are decodable
+// by the reference lz4 CLI, and that frames written by the reference lz4 CLI
+// are decodable by FrameReader. It is skipped if lz4 isn't on PATH.
+func TestFrameCLIInterop(t *testing.T) {
+	if _, err := exec.LookPath("lz4"); err != nil {
+		t.Skip("lz4 CLI not found on PATH")
+	}
+
+	r := rand.New(rand.NewSource(42))
+	cases := [][]byte{
+		bytes.Repeat([]byte("AB"), 1000),                    // ends mid-match
+		bytes.Repeat([]byte("the quick brown fox "), 10000), // multiple blocks
+		nil,
+	}
+	for i := 0; i < 20; i++ {
+		n := r.Intn(5000)
+		data := make([]byte, n)
+		switch i % 3 {
+		case 0:
+			r.Read(data)
+		case 1:
+			for j := range data {
+				data[j] = byte('a' + j%4)
+			}
+		case 2:
+			copy(data, bytes.Repeat([]byte("hello world "), n/12+1))
+		}
+		cases = append(cases, data)
+	}
+
+	for i, data := range cases {
+		var buf bytes.Buffer
+		fw := NewFrameWriterDescriptor(&buf, FrameDescriptor{ContentChecksum: true})
+		if _, err := fw.Write(data); err != nil {
+			t.Fatalf("case %d: Write: %v", i, err)
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatalf("case %d: Close: %v", i, err)
+		}
+
+		in, err := os.CreateTemp(t.TempDir(), "interop-*.lz4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := in.Write(buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		in.Close()
+		outPath := in.Name() + ".dec"
+
+		out, err := exec.Command("lz4", "-f", "-d", in.Name(), outPath).CombinedOutput()
+		if err != nil {
+			t.Fatalf("case %d: lz4 -d failed: %v: %s", i, err, out)
+		}
+		decoded, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("case %d: lz4 CLI decoded content mismatch", i)
+		}
+
+		// And the reverse direction: have the CLI compress, we decode.
+		cliOut := in.Name() + ".cli.lz4"
+		if out, err := exec.Command("lz4", "-f", "-z", outPath, cliOut).CombinedOutput(); err != nil {
+			t.Fatalf("case %d: lz4 -z failed: %v: %s", i, err, out)
+		}
+		cliCompressed, err := os.ReadFile(cliOut)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr := NewFrameReader(bytes.NewReader(cliCompressed))
+		ours, err := io.ReadAll(fr)
+		if err != nil {
+			t.Fatalf("case %d: FrameReader on CLI output: %v", i, err)
+		}
+		if !bytes.Equal(ours, data) {
+			t.Fatalf("case %d: FrameReader decoded CLI output mismatch", i)
+		}
+	}
+}
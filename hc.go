@@ -0,0 +1,230 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// This file implements a high-compression encoder (LZ4HC). Unlike Encode,
+// which keeps a single most-recent candidate per hash slot, EncodeHC chains
+// every position sharing a hash together so a match search can walk back
+// through all of them, trading encode time for a better compression ratio.
+// The bitstream it produces is ordinary LZ4 blocks, decodable by Decode.
+
+package lz4
+
+// noEntry marks an empty hash/chain table slot: no position can ever equal it.
+const noEntry = 0xFFFFFFFF
+
+// maxDistance is the largest back-reference distance the LZ4 block format
+// can encode in its 16-bit offset field.
+const maxDistance = 0xFFFF
+
+// MinCompressionLevel and MaxCompressionLevel bound the level accepted by
+// EncodeHC. MinCompressionLevel and any level below it fall back to the
+// fast Encode path; levels above MaxCompressionLevel are clamped down to it.
+const (
+	MinCompressionLevel = 1
+	MaxCompressionLevel = 12
+)
+
+// nbAttemptsForLevel maps a compression level to how many positions along a
+// hash chain the matcher may walk before settling for the longest match
+// found so far. It doubles with each level up to the point where walking
+// the whole 64KB window stops being worth the extra attempts, then jumps to
+// an exhaustive search for the top levels.
+func nbAttemptsForLevel(level int) int {
+	switch {
+	case level <= MinCompressionLevel:
+		return 0
+	case level <= 9:
+		n := 1 << uint(level-1)
+		if n > 256 {
+			n = 256
+		}
+		return n
+	default:
+		return 1 << 16
+	}
+}
+
+// chainSize and chainMask bound the hash chain to the 64KB window a match
+// can ever reference - positions further back than maxDistance are never a
+// valid match anyway, so the chain only needs one slot per window position,
+// not one per input byte. It is indexed by pos&chainMask rather than pos
+// directly, so slots recycle every 64KB the way the hash table's own
+// positions implicitly do.
+const (
+	chainSize = maxDistance + 1
+	chainMask = chainSize - 1
+)
+
+// hcEncoder reuses encoder's src/dst/writeLiterals machinery and adds the
+// hash-chain match finder on top of it.
+type hcEncoder struct {
+	encoder
+	table      []uint32
+	chain      []uint32
+	nbAttempts int
+	matchLimit int
+}
+
+func (e *hcEncoder) insert(pos uint32) {
+	if int(pos)+minMatch > len(e.src) {
+		return
+	}
+	sequence := e.readUint32(int(pos))
+	hash := (sequence * 2654435761) >> hashShift
+	e.chain[pos&chainMask] = e.table[hash]
+	e.table[hash] = pos
+}
+
+func (e *hcEncoder) matchLength(ref, pos uint32) uint32 {
+	start := pos
+	max := uint32(e.matchLimit)
+	for pos < max && e.src[ref] == e.src[pos] {
+		ref++
+		pos++
+	}
+	return pos - start
+}
+
+// findLongestMatch walks the hash chain at pos up to nbAttempts steps,
+// returning the longest match found whose distance fits in 16 bits. A
+// length below minMatch (0 included) means nothing usable was found.
+func (e *hcEncoder) findLongestMatch(pos uint32) (ref, length uint32) {
+
+	if int(pos)+minMatch > e.matchLimit {
+		return 0, 0
+	}
+
+	sequence := e.readUint32(int(pos))
+	hash := (sequence * 2654435761) >> hashShift
+	candidate := e.table[hash]
+
+	for attempts := e.nbAttempts; candidate != noEntry && attempts > 0; attempts-- {
+		if pos-candidate > maxDistance {
+			break
+		}
+		if l := e.matchLength(candidate, pos); l > length {
+			length = l
+			ref = candidate
+		}
+		candidate = e.chain[candidate&chainMask]
+	}
+
+	return
+}
+
+// EncodeHC compresses src into dst at the given compression level (1-12),
+// producing a block decodable by the same decoder as Encode. Higher levels
+// search further down each hash chain and apply lazy matching for a better
+// ratio at the cost of encode time; level 1 is the plain Encode fast path.
+func EncodeHC(dst, src []byte, level int) ([]byte, error) {
+
+	if len(src) >= MaxInputSize {
+		return nil, ErrTooLarge
+	}
+
+	if level <= MinCompressionLevel {
+		return Encode(dst, src)
+	}
+	if level > MaxCompressionLevel {
+		level = MaxCompressionLevel
+	}
+
+	if n := CompressBound(len(src)); len(dst) < n {
+		dst = make([]byte, n)
+	}
+
+	matchLimit := len(src) - lastLiterals
+	if matchLimit < 0 {
+		matchLimit = 0
+	}
+
+	e := &hcEncoder{
+		encoder:    encoder{src: src, dst: dst},
+		table:      make([]uint32, hashTableSize),
+		chain:      make([]uint32, chainSize),
+		nbAttempts: nbAttemptsForLevel(level),
+		matchLimit: matchLimit,
+	}
+	for ii := range e.table {
+		e.table[ii] = noEntry
+	}
+	for ii := range e.chain {
+		e.chain[ii] = noEntry
+	}
+
+	for int(e.pos)+minMatch <= e.matchLimit {
+
+		ref, length := e.findLongestMatch(e.pos)
+		e.insert(e.pos)
+
+		if length < minMatch {
+			e.pos++
+			continue
+		}
+
+		for int(e.pos)+1+minMatch <= e.matchLimit {
+			nextRef, nextLength := e.findLongestMatch(e.pos + 1)
+			e.insert(e.pos + 1)
+			if nextLength <= length+1 {
+				break
+			}
+			e.pos++
+			ref, length = nextRef, nextLength
+		}
+
+		ln := e.pos - e.anchor
+		back := e.pos - ref
+		anchor := e.anchor
+
+		matchStart := e.pos
+		e.pos += length
+		e.anchor = e.pos
+
+		for p := matchStart + minMatch; p < e.pos; p++ {
+			e.insert(p)
+		}
+
+		mlLen := length - minMatch
+
+		e.writeLiterals(ln, mlLen, anchor)
+		e.dst[e.dpos] = uint8(back)
+		e.dst[e.dpos+1] = uint8(back >> 8)
+		e.dpos += 2
+
+		if mlLen > mlMask-1 {
+			mlLen -= mlMask
+			for mlLen > 254 {
+				mlLen -= 255
+				e.dst[e.dpos] = 255
+				e.dpos++
+			}
+			e.dst[e.dpos] = byte(mlLen)
+			e.dpos++
+		}
+	}
+
+	e.writeLiterals(uint32(len(e.src))-e.anchor, 0, e.anchor)
+	return e.dst[:e.dpos], nil
+}
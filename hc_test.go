@@ -0,0 +1,129 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lz4
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestEncodeHCRoundTrip(t *testing.T) {
+	compressible := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 2000)
+	random := make([]byte, 20000)
+	rand.New(rand.NewSource(2)).Read(random)
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"compressible", compressible},
+		{"random", random},
+		{"empty", nil},
+		{"tiny", []byte("hi")},
+		{"ends-on-match", bytes.Repeat([]byte("AB"), 1000)},
+	}
+
+	for _, c := range cases {
+		for _, level := range []int{1, 2, 6, 9, 12} {
+			t.Run(c.name, func(t *testing.T) {
+				dst := make([]byte, CompressBound(len(c.data)))
+				compressed, err := EncodeHC(dst, c.data, level)
+				if err != nil {
+					t.Fatalf("level %d: EncodeHC: %v", level, err)
+				}
+				out, err := Decode(nil, compressed)
+				if err != nil {
+					t.Fatalf("level %d: Decode: %v", level, err)
+				}
+				if !bytes.Equal(out, c.data) {
+					t.Fatalf("level %d: roundtrip mismatch", level)
+				}
+			})
+		}
+	}
+}
+
+// TestEncodeHCChainIsBounded makes sure the hash chain is sized to the
+// match window rather than to the input, which used to make high
+// compression mode allocate one uint32 per input byte (4GB of chain for a
+// 1GB input).
+func TestEncodeHCChainIsBounded(t *testing.T) {
+	if chainSize > maxDistance+1 {
+		t.Fatalf("chainSize = %d, want it bounded to the match window (<= %d)", chainSize, maxDistance+1)
+	}
+}
+
+// TestEncodeHCCLIInterop frames an EncodeHC block by hand and checks the
+// reference lz4 CLI can decode it, the same way a FrameWriter-produced
+// block is checked in frame_test.go. Skipped if lz4 isn't on PATH.
+func TestEncodeHCCLIInterop(t *testing.T) {
+	if _, err := exec.LookPath("lz4"); err != nil {
+		t.Skip("lz4 CLI not found on PATH")
+	}
+
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 2000)
+	compressed, err := EncodeHC(nil, data, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	fw := NewFrameWriterDescriptor(&buf, FrameDescriptor{BlockMaxSize: Block4MB})
+	if err := fw.writeHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.writeRawBlock(compressed, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := os.CreateTemp(t.TempDir(), "hc-interop-*.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	in.Close()
+	outPath := in.Name() + ".dec"
+
+	out, err := exec.Command("lz4", "-f", "-d", in.Name(), outPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("lz4 -d failed: %v: %s", err, out)
+	}
+	decoded, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("lz4 CLI decoded content mismatch")
+	}
+}
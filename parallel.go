@@ -0,0 +1,280 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// This file splits large inputs into independent, fixed-size blocks and
+// compresses/decompresses them across a pool of worker goroutines, writing
+// (or reading) the result as a block-independent LZ4 frame.
+
+package lz4
+
+import (
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ParallelEncoder compresses a source in fixed-size, independent blocks
+// across a pool of worker goroutines, then writes the result as an LZ4
+// frame with the block-independence flag set.
+type ParallelEncoder struct {
+	// Concurrency is the number of worker goroutines. 0 means
+	// runtime.GOMAXPROCS(0).
+	Concurrency int
+
+	// BlockMaxSize is the size each block of the source is split into. 0
+	// means Block1MB. Must be one of Block64KB, Block256KB, Block1MB or
+	// Block4MB.
+	BlockMaxSize int
+
+	// BlockChecksum and ContentChecksum enable the matching frame
+	// descriptor flags, as in FrameDescriptor.
+	BlockChecksum   bool
+	ContentChecksum bool
+}
+
+// NewParallelEncoder returns a ParallelEncoder with default concurrency and
+// block size.
+func NewParallelEncoder() *ParallelEncoder {
+	return &ParallelEncoder{
+		Concurrency:  runtime.GOMAXPROCS(0),
+		BlockMaxSize: Block1MB,
+	}
+}
+
+type parallelBlockResult struct {
+	payload      []byte
+	uncompressed bool
+}
+
+// Encode splits src into p.BlockMaxSize blocks, compresses them across
+// p.Concurrency worker goroutines and writes the resulting frame to w.
+func (p *ParallelEncoder) Encode(w io.Writer, src []byte) error {
+
+	blockSize := p.BlockMaxSize
+	if blockSize == 0 {
+		blockSize = Block1MB
+	}
+	concurrency := p.Concurrency
+	if concurrency == 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	desc := FrameDescriptor{
+		BlockIndependence: true,
+		BlockChecksum:     p.BlockChecksum,
+		ContentChecksum:   p.ContentChecksum,
+		ContentSize:       uint64(len(src)),
+		BlockMaxSize:      blockSize,
+	}
+
+	fw := NewFrameWriterDescriptor(w, desc)
+	if err := fw.writeHeader(); err != nil {
+		return err
+	}
+
+	numBlocks := (len(src) + blockSize - 1) / blockSize
+	results := make([]parallelBlockResult, numBlocks)
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	worker := func() {
+		defer wg.Done()
+
+		hashTable := make([]uint32, hashTableSize)
+		var dst []byte
+
+		for idx := range jobs {
+			start := idx * blockSize
+			end := start + blockSize
+			if end > len(src) {
+				end = len(src)
+			}
+			block := src[start:end]
+
+			need := CompressBound(len(block))
+			if cap(dst) < need {
+				dst = make([]byte, need)
+			}
+			for ii := range hashTable {
+				hashTable[ii] = 0
+			}
+
+			compressed := encodeBlock(dst[:need], block, hashTable)
+			if len(compressed) >= len(block) {
+				results[idx] = parallelBlockResult{payload: block, uncompressed: true}
+			} else {
+				payload := make([]byte, len(compressed))
+				copy(payload, compressed)
+				results[idx] = parallelBlockResult{payload: payload}
+			}
+		}
+	}
+
+	for ii := 0; ii < concurrency; ii++ {
+		wg.Add(1)
+		go worker()
+	}
+	for idx := 0; idx < numBlocks; idx++ {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, r := range results {
+		if err := fw.writeRawBlock(r.payload, r.uncompressed); err != nil {
+			return err
+		}
+	}
+
+	if desc.ContentChecksum {
+		fw.contentHash.Write(src)
+	}
+
+	return fw.Close()
+}
+
+// ParallelDecoder decompresses a block-independent LZ4 frame, pipelining
+// block decompression across a pool of worker goroutines. Frames that do
+// not advertise block independence are decoded sequentially instead, since
+// their blocks may depend on each other's sliding window.
+type ParallelDecoder struct {
+	// Concurrency is the number of worker goroutines. 0 means
+	// runtime.GOMAXPROCS(0).
+	Concurrency int
+}
+
+// NewParallelDecoder returns a ParallelDecoder with default concurrency.
+func NewParallelDecoder() *ParallelDecoder {
+	return &ParallelDecoder{Concurrency: runtime.GOMAXPROCS(0)}
+}
+
+// Decode reads and decompresses the LZ4 frame in r, returning its content.
+func (p *ParallelDecoder) Decode(r io.Reader) ([]byte, error) {
+
+	fr := NewFrameReader(r)
+	if err := fr.readHeaderOnce(); err != nil {
+		return nil, err
+	}
+
+	if !fr.desc.BlockIndependence {
+		return io.ReadAll(fr)
+	}
+
+	concurrency := p.Concurrency
+	if concurrency == 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	var blocks []parallelBlockResult
+	for {
+		var sizeField [4]byte
+		if err := fr.readFull(sizeField[:]); err != nil {
+			return nil, err
+		}
+
+		size := binary.LittleEndian.Uint32(sizeField[:])
+		if size == 0 {
+			break
+		}
+
+		uncompressed := size&blockUncompressedFlag != 0
+		size &^= blockUncompressedFlag
+
+		payload := make([]byte, size)
+		if err := fr.readFull(payload); err != nil {
+			return nil, err
+		}
+
+		if fr.desc.BlockChecksum {
+			var sum [4]byte
+			if err := fr.readFull(sum[:]); err != nil {
+				return nil, err
+			}
+			if binary.LittleEndian.Uint32(sum[:]) != xxh32Checksum(0, payload) {
+				return nil, ErrBlockChecksum
+			}
+		}
+
+		blocks = append(blocks, parallelBlockResult{payload: payload, uncompressed: uncompressed})
+	}
+
+	decoded := make([][]byte, len(blocks))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(blocks))
+
+	worker := func() {
+		defer wg.Done()
+		for idx := range jobs {
+			b := blocks[idx]
+			if b.uncompressed {
+				decoded[idx] = b.payload
+				continue
+			}
+			dst := make([]byte, fr.blockSize)
+			out, err := Decode(dst, b.payload)
+			if err != nil {
+				errs <- err
+				continue
+			}
+			decoded[idx] = out
+		}
+	}
+
+	for ii := 0; ii < concurrency; ii++ {
+		wg.Add(1)
+		go worker()
+	}
+	for idx := range blocks {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+	}
+
+	out := make([]byte, 0, fr.desc.ContentSize)
+	for _, b := range decoded {
+		out = append(out, b...)
+	}
+
+	if fr.desc.ContentChecksum {
+		var sum [4]byte
+		if err := fr.readFull(sum[:]); err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != xxh32Checksum(0, out) {
+			return nil, ErrContentChecksum
+		}
+	}
+
+	return out, nil
+}
@@ -1,195 +1,391 @@
-/*
- * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
- *
- * Redistribution and use in source and binary forms, with or without modification,
- * are permitted provided that the following conditions are met:
- *
- *    1. Redistributions of source code must retain the above copyright notice, this
- *       list of conditions and the following disclaimer.
- *
- *    2. Redistributions in binary form must reproduce the above copyright notice,
- *       this list of conditions and the following disclaimer in the documentation
- *       and/or other materials provided with the distribution.
- *
- * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
- * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
- * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
- * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
- * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
- * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
- * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
- * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
- * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
- * THE POSSIBILITY OF SUCH DAMAGE.
- */
-
-package lz4
-
-import (
-	"bufio"
-	"io"
-)
-
-const (
-	mlBits     = 4
-	mlMask     = (1 << mlBits) - 1
-	runBits    = 8 - mlBits
-	runMask    = (1 << runBits) - 1
-	bufferSize = 128 << 10
-	flushSize  = 1 << 16
-)
-
-type decoder struct {
-	r   io.ByteReader
-	w   *bufio.Writer
-	buf []byte
-	pos uint32
-	ref uint32
-}
-
-func (d *decoder) getLen() (uint32, error) {
-
-	length := uint32(0)
-	ln, err := d.r.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	for ln == 255 {
-		length += 255
-		ln, err = d.r.ReadByte()
-		if err != nil {
-			return 0, err
-		}
-	}
-	length += uint32(ln)
-
-	return length, nil
-}
-
-func (d *decoder) readUint16() (uint16, error) {
-	b1, err := d.r.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b2, err := d.r.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	u16 := (uint16(b2) << 8) | uint16(b1)
-	return u16, nil
-}
-
-func (d *decoder) cp(length, decr uint32) {
-	d.flush(length)
-
-	for ii := uint32(0); ii < length; ii++ {
-		d.buf[d.pos+ii] = d.buf[d.ref+ii]
-	}
-	d.pos += length
-	d.ref += length - decr
-}
-
-func (d *decoder) consume(length uint32) error {
-
-	d.flush(length)
-
-	for ii := uint32(0); ii < length; ii++ {
-		by, err := d.r.ReadByte()
-		if err != nil {
-			return d.finish(err)
-		}
-		d.buf[d.pos] = by
-		d.pos++
-	}
-
-	return nil
-}
-
-func (d *decoder) flush(length uint32) {
-
-	if d.pos+length > bufferSize {
-		s := d.ref - flushSize
-		d.w.Write(d.buf[0:s])
-		n := d.pos - d.ref
-		copy(d.buf[0:flushSize+n], d.buf[s:d.pos])
-		d.pos = flushSize + n
-		d.ref = flushSize
-	}
-}
-
-func (d *decoder) finish(err error) error {
-	if err == io.EOF {
-		d.w.Write(d.buf[0:d.pos])
-		return d.w.Flush()
-	}
-
-	return err
-}
-
-func decode1(pw *io.PipeWriter, r io.ByteReader) error {
-
-	w := bufio.NewWriter(pw)
-	d := decoder{r, w, make([]byte, bufferSize), 0, 0}
-
-	decr := []uint32{0, 3, 2, 3}
-
-	for {
-		code, err := d.r.ReadByte()
-		if err != nil {
-			return d.finish(err)
-		}
-
-		length := uint32(code >> mlBits)
-		if length == runMask {
-			ln, err := d.getLen()
-			if err != nil {
-				return d.finish(err)
-			}
-			length += ln
-		}
-
-		err = d.consume(length)
-		if err != nil {
-			return d.finish(err)
-		}
-
-		back, err := d.readUint16()
-		if err != nil {
-			return d.finish(err)
-		}
-		d.ref = d.pos - uint32(back)
-
-		length = uint32(code & mlMask)
-		if length == mlMask {
-			ln, err := d.getLen()
-			if err != nil {
-				return d.finish(err)
-			}
-			length += ln
-		}
-
-		literal := d.pos - d.ref
-		if literal < 4 {
-			d.cp(4, decr[literal])
-		} else {
-			length += 4
-		}
-
-		d.cp(length, 0)
-	}
-	panic("unreachable")
-}
-
-func decode(r io.Reader, pw *io.PipeWriter) {
-	br, ok := r.(io.ByteReader)
-	if !ok {
-		br = bufio.NewReader(r)
-	}
-	pw.CloseWithError(decode1(pw, br))
-}
-
-func NewReader(r io.Reader) io.ReadCloser {
-	pr, pw := io.Pipe()
-	go decode(r, pw)
-	return pr
-}
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lz4
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrInvalidBlock is returned by Decode and DecodeWithDict when a block's
+// back-reference distance exceeds the amount of history available to it,
+// which can only happen with corrupt input or a dictionary mismatch.
+var ErrInvalidBlock = errors.New("lz4: invalid back-reference in compressed block")
+
+const (
+	mlBits     = 4
+	mlMask     = (1 << mlBits) - 1
+	runBits    = 8 - mlBits
+	runMask    = (1 << runBits) - 1
+	bufferSize = 128 << 10
+	flushSize  = 1 << 16
+)
+
+// reader is the streaming counterpart to decodeBlock: instead of requiring
+// the whole compressed stream up front, it pulls only as many bytes from r
+// as it takes to satisfy each Read, decoding one literal-run/match sequence
+// at a time. Decoded bytes not yet claimed by a caller sit in buf[out:pos];
+// buf is compacted once it grows past bufferSize, same as the old
+// pipe-based decoder did, except the history kept across a compaction is
+// never allowed to fall behind out, so undelivered output is never
+// discarded.
+type reader struct {
+	r   io.ByteReader
+	buf []byte
+	pos uint32
+	ref uint32
+	out uint32
+	err error
+}
+
+// NewReader returns a reader that decompresses r on demand as it is read,
+// as produced by Encode or a Writer from this package. Unlike a one-shot
+// Decode, it never needs the whole compressed stream in memory at once.
+func NewReader(r io.Reader) io.ReadCloser {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &reader{r: br, buf: make([]byte, bufferSize)}
+}
+
+func (d *reader) getLen() (uint32, error) {
+
+	length := uint32(0)
+	ln, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	for ln == 255 {
+		length += 255
+		ln, err = d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	length += uint32(ln)
+
+	return length, nil
+}
+
+func (d *reader) readUint16() (uint16, error) {
+	b1, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	b2, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	return (uint16(b2) << 8) | uint16(b1), nil
+}
+
+// room makes sure buf can hold length more bytes from pos, compacting
+// everything more than flushSize bytes before ref - but never past out, since that would discard
+// decoded output no caller has read yet - and growing buf outright if
+// compaction alone isn't enough. length comes straight off the wire via
+// getLen, which has no inherent upper bound, so the arithmetic here is done
+// in uint64 and checked against MaxInputSize before it ever reaches a
+// uint32 allocation size or index, to keep an oversized length from
+// wrapping around instead of failing cleanly.
+func (d *reader) room(length uint32) error {
+	need := uint64(d.pos) + uint64(length)
+	if need > MaxInputSize {
+		return ErrInvalidBlock
+	}
+	if need <= uint64(len(d.buf)) {
+		return nil
+	}
+
+	discard := d.ref
+	if discard > flushSize {
+		discard -= flushSize
+	} else {
+		discard = 0
+	}
+	if discard > d.out {
+		discard = d.out
+	}
+	if discard > 0 {
+		copy(d.buf, d.buf[discard:d.pos])
+		d.pos -= discard
+		d.ref -= discard
+		d.out -= discard
+		need = uint64(d.pos) + uint64(length)
+	}
+
+	if need > uint64(len(d.buf)) {
+		grown := make([]byte, need+need/2+64)
+		copy(grown, d.buf[:d.pos])
+		d.buf = grown
+	}
+	return nil
+}
+
+func (d *reader) cp(length, decr uint32) error {
+	if err := d.room(length); err != nil {
+		return err
+	}
+
+	for ii := uint32(0); ii < length; ii++ {
+		d.buf[d.pos+ii] = d.buf[d.ref+ii]
+	}
+	d.pos += length
+	d.ref += length - decr
+	return nil
+}
+
+func (d *reader) consume(length uint32) error {
+	if err := d.room(length); err != nil {
+		return err
+	}
+
+	for ii := uint32(0); ii < length; ii++ {
+		by, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		d.buf[d.pos] = by
+		d.pos++
+	}
+
+	return nil
+}
+
+// decodeSequence decodes one literal-run/match sequence into buf, advancing
+// pos. It returns io.EOF once r runs dry, whether that happens cleanly
+// between sequences or partway through one - a truncated final sequence is
+// not an error, since the block format has no explicit end marker and the
+// stream is expected to simply stop.
+func (d *reader) decodeSequence() error {
+
+	decr := []uint32{0, 3, 2, 3}
+
+	code, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	length := uint32(code >> mlBits)
+	if length == runMask {
+		ln, err := d.getLen()
+		if err != nil {
+			return err
+		}
+		length += ln
+	}
+
+	if err := d.consume(length); err != nil {
+		return err
+	}
+
+	back, err := d.readUint16()
+	if err != nil {
+		return err
+	}
+	if uint32(back) > d.pos {
+		return ErrInvalidBlock
+	}
+	d.ref = d.pos - uint32(back)
+
+	length = uint32(code & mlMask)
+	if length == mlMask {
+		ln, err := d.getLen()
+		if err != nil {
+			return err
+		}
+		length += ln
+	}
+
+	literal := d.pos - d.ref
+	if literal < 4 {
+		if err := d.cp(4, decr[literal]); err != nil {
+			return err
+		}
+	} else {
+		length += 4
+	}
+
+	return d.cp(length, 0)
+}
+
+// Read decodes just enough of the stream to return some bytes, leaving
+// anything decoded beyond what p can hold buffered for the next call.
+func (d *reader) Read(p []byte) (int, error) {
+	for d.out >= d.pos && d.err == nil {
+		d.err = d.decodeSequence()
+	}
+
+	if d.out < d.pos {
+		n := copy(p, d.buf[d.out:d.pos])
+		d.out += uint32(n)
+		return n, nil
+	}
+
+	if d.err == io.EOF {
+		return 0, io.EOF
+	}
+	return 0, d.err
+}
+
+func (d *reader) Close() error {
+	return nil
+}
+
+// Decode decompresses a single LZ4 block, as produced by Encode, from src
+// into dst and returns the slice of dst holding the decompressed data. dst
+// is grown as needed if it is too small.
+func Decode(dst, src []byte) ([]byte, error) {
+	return decodeBlock(dst, 0, src)
+}
+
+// decodeBlock is the guts of Decode. startPos lets DecodeWithDict seed dst
+// with dictionary bytes ahead of pos 0, so that back-references landing
+// before startPos resolve into the dictionary rather than underflowing.
+func decodeBlock(dst []byte, startPos uint32, src []byte) ([]byte, error) {
+
+	pos, ref := startPos, uint32(0)
+	var spos uint32
+	decr := []uint32{0, 3, 2, 3}
+
+	readByte := func() (byte, error) {
+		if int(spos) >= len(src) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		b := src[spos]
+		spos++
+		return b, nil
+	}
+
+	getLen := func() (uint32, error) {
+		length := uint32(0)
+		for {
+			b, err := readByte()
+			if err != nil {
+				return 0, err
+			}
+			length += uint32(b)
+			if b != 255 {
+				break
+			}
+		}
+		return length, nil
+	}
+
+	readUint16 := func() (uint16, error) {
+		b1, err := readByte()
+		if err != nil {
+			return 0, err
+		}
+		b2, err := readByte()
+		if err != nil {
+			return 0, err
+		}
+		return (uint16(b2) << 8) | uint16(b1), nil
+	}
+
+	grow := func(need uint32) {
+		if need > uint32(len(dst)) {
+			grown := make([]byte, need+need/2+64)
+			copy(grown, dst)
+			dst = grown
+		}
+	}
+
+	cp := func(length, decrement uint32) {
+		grow(pos + length)
+		for ii := uint32(0); ii < length; ii++ {
+			dst[pos+ii] = dst[ref+ii]
+		}
+		pos += length
+		ref += length - decrement
+	}
+
+	consume := func(length uint32) error {
+		grow(pos + length)
+		if int(spos)+int(length) > len(src) {
+			return io.ErrUnexpectedEOF
+		}
+		copy(dst[pos:pos+length], src[spos:spos+length])
+		pos += length
+		spos += length
+		return nil
+	}
+
+	for int(spos) < len(src) {
+		code, err := readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		length := uint32(code >> mlBits)
+		if length == runMask {
+			ln, err := getLen()
+			if err != nil {
+				return nil, err
+			}
+			length += ln
+		}
+
+		if err := consume(length); err != nil {
+			return nil, err
+		}
+
+		if int(spos) >= len(src) {
+			break
+		}
+
+		back, err := readUint16()
+		if err != nil {
+			return nil, err
+		}
+		if uint32(back) > pos {
+			return nil, ErrInvalidBlock
+		}
+		ref = pos - uint32(back)
+
+		length = uint32(code & mlMask)
+		if length == mlMask {
+			ln, err := getLen()
+			if err != nil {
+				return nil, err
+			}
+			length += ln
+		}
+
+		literal := pos - ref
+		if literal < 4 {
+			cp(4, decr[literal])
+		} else {
+			length += 4
+		}
+
+		cp(length, 0)
+	}
+
+	return dst[:pos], nil
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lz4
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// streamRoundTrip writes data to a Writer in chunks of writeSize (0 means
+// one single Write), then reads it back through a Reader in chunks of
+// readSize (0 means 64KB chunks), asserting the result matches.
+func streamRoundTrip(t *testing.T, data []byte, writeSize, readSize int) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if writeSize <= 0 {
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	} else {
+		for off := 0; off < len(data); off += writeSize {
+			end := off + writeSize
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err := w.Write(data[off:end]); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r := NewReader(&buf)
+	defer r.Close()
+
+	var out []byte
+	if readSize <= 0 {
+		readSize = 64 << 10
+	}
+	chunk := make([]byte, readSize)
+	for {
+		n, err := r.Read(chunk)
+		out = append(out, chunk[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatalf("roundtrip mismatch: got %d bytes, want %d", len(out), len(data))
+	}
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	compressible := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 20000)
+	random := make([]byte, 300000)
+	rand.New(rand.NewSource(5)).Read(random)
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"compressible", compressible},
+		{"random", random},
+		{"empty", nil},
+		{"tiny", []byte("hi")},
+		{"ends-on-match", bytes.Repeat([]byte("AB"), 10000)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			streamRoundTrip(t, c.data, 0, 0)
+		})
+	}
+}
+
+// TestStreamSmallWritesAndReads exercises Writer/Reader a few bytes at a
+// time, the pattern most likely to trip up the compaction logic in
+// reader.room. Its data stays below writerCompactThreshold, so
+// writer.compact never compacts here; TestStreamCompactsLargeInput covers it.
+func TestStreamSmallWritesAndReads(t *testing.T) {
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 5000)
+	streamRoundTrip(t, data, 7, 3)
+}
+
+// TestWriterProcessReservesLastLiterals is a white-box check that
+// writer.process never lets a match consume all the way up to the current
+// end of buf, the same margin encodeBlockFrom and EncodeHC reserve. A
+// highly repetitive, non-final buffer is the adversarial case: without the
+// margin, e.anchor would land exactly at len(buf), and if that call
+// happened to be the last data the caller ever wrote, Close's final flush
+// would emit a trailing literal run of zero.
+func TestWriterProcessReservesLastLiterals(t *testing.T) {
+	var out bytes.Buffer
+	e := &writer{
+		w:         bufio.NewWriter(&out),
+		hashTable: make([]uint32, hashTableSize),
+		buf:       bytes.Repeat([]byte("AB"), 1000),
+		step:      1,
+		limit:     incompressible,
+	}
+	for ii := range e.hashTable {
+		e.hashTable[ii] = noEntry
+	}
+
+	if err := e.process(false); err != nil {
+		t.Fatalf("process: %v", err)
+	}
+
+	if int(e.anchor) > len(e.buf)-lastLiterals {
+		t.Fatalf("anchor = %d, want <= %d (len(buf)-lastLiterals)", e.anchor, len(e.buf)-lastLiterals)
+	}
+}
+
+// TestStreamCompactsLargeInput writes enough data to push a Writer and
+// Reader through several rounds of their respective buffer-compaction
+// thresholds.
+func TestStreamCompactsLargeInput(t *testing.T) {
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(6)).Read(data)
+	// Make it partly compressible so matches span compactions too: every
+	// other 64KB stretch repeats the 32KB before it, well inside the window.
+	for off := 64 << 10; off < len(data); off += 128 << 10 {
+		for i := off; i < off+(64<<10); i++ {
+			data[i] = data[i-(32<<10)]
+		}
+	}
+	streamRoundTrip(t, data, 64<<10, 64<<10)
+}
+
+// TestWriterInteropWithDecode checks that Writer's output is also readable
+// by the one-shot Decode, since both document themselves as producing/
+// consuming the same block format.
+func TestWriterInteropWithDecode(t *testing.T) {
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 2000)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	out, err := Decode(nil, buf.Bytes())
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("roundtrip mismatch: got %d bytes, want %d", len(out), len(data))
+	}
+}
+
+// TestReaderInteropWithEncode checks the reverse direction: a block
+// produced by the one-shot Encode is readable through a streaming Reader.
+func TestReaderInteropWithEncode(t *testing.T) {
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 2000)
+
+	compressed, err := Encode(nil, data)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("roundtrip mismatch: got %d bytes, want %d", len(out), len(data))
+	}
+}
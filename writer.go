@@ -25,7 +25,11 @@
 
 package lz4
 
-import "errors"
+import (
+	"bufio"
+	"errors"
+	"io"
+)
 
 const (
 	minMatch              = 4
@@ -110,7 +114,36 @@ func Encode(dst, src []byte) ([]byte, error) {
 		dst = make([]byte, n)
 	}
 
-	e := encoder{src: src, dst: dst, hashTable: make([]uint32, hashTableSize)}
+	return encodeBlock(dst, src, make([]uint32, hashTableSize)), nil
+}
+
+// encodeBlock is the guts of Encode, factored out so callers that already
+// hold a hashTable - such as ParallelEncoder, compressing many blocks back
+// to back - can reuse it instead of allocating one per block. hashTable
+// must be zeroed and have length hashTableSize.
+func encodeBlock(dst, src []byte, hashTable []uint32) []byte {
+	return encodeBlockFrom(dst, src, hashTable, 0)
+}
+
+// lastLiterals is the number of trailing bytes of a block that must always
+// be emitted as literals, never as part of a match. The reference LZ4
+// decoder (and the lz4 CLI) rejects a block whose final sequence has no
+// trailing literal run, so the match search below must never let a match
+// reach all the way to the end of src.
+const lastLiterals = 5
+
+// encodeBlockFrom is encodeBlock with the match search starting at start
+// instead of the beginning of src, used by EncodeWithDict so that bytes
+// before start - a preset dictionary - can be referenced by back-matches
+// without ever being emitted as literals themselves.
+func encodeBlockFrom(dst, src []byte, hashTable []uint32, start uint32) []byte {
+
+	e := encoder{src: src, dst: dst, hashTable: hashTable, pos: start, anchor: start}
+
+	matchLimit := len(e.src) - lastLiterals
+	if matchLimit < 0 {
+		matchLimit = 0
+	}
 
 	var (
 		step  uint32 = 1
@@ -118,9 +151,9 @@ func Encode(dst, src []byte) ([]byte, error) {
 	)
 
 	for {
-		if int(e.pos)+4 >= len(e.src) {
+		if int(e.pos)+4 >= matchLimit {
 			e.writeLiterals(uint32(len(e.src))-e.anchor, 0, e.anchor)
-			return e.dst[:e.dpos], nil
+			return e.dst[:e.dpos]
 		}
 		sequence := e.readUint32(int(e.pos))
 		hash := (sequence * 2654435761) >> hashShift
@@ -153,7 +186,7 @@ func Encode(dst, src []byte) ([]byte, error) {
 		ref += minMatch
 		e.anchor = e.pos
 
-		for int(e.pos) < len(e.src) && e.src[e.pos] == e.src[ref] {
+		for int(e.pos) < matchLimit && e.src[e.pos] == e.src[ref] {
 			e.pos++
 			ref++
 		}
@@ -181,3 +214,246 @@ func Encode(dst, src []byte) ([]byte, error) {
 		e.anchor = e.pos
 	}
 }
+
+// writerCompactThreshold is how large buf is allowed to grow, holding
+// already-flushed history plus the pending literal run, before writer
+// compacts away the part of it no future match can reference.
+const writerCompactThreshold = 1 << 20
+
+// writer is the streaming counterpart to encodeBlockFrom: instead of
+// matching over a whole src slice in one pass, it appends each Write to buf
+// and runs the same hash-match loop over whatever has accumulated,
+// flushing each literal-run/match sequence to w as soon as it is found.
+// Bytes from anchor onward are held back, since a longer match starting
+// there might still turn up once more input arrives.
+type writer struct {
+	w         *bufio.Writer
+	hashTable []uint32
+	buf       []byte
+	pos       uint32
+	anchor    uint32
+	step      uint32
+	limit     uint32
+	closed    bool
+}
+
+// NewWriter returns a writer that compresses everything written to it and
+// flushes the result to w, producing a stream Decode or a Reader from this
+// package can decompress. Close must be called to flush the final block.
+func NewWriter(w io.Writer) io.WriteCloser {
+	e := &writer{
+		w:         bufio.NewWriter(w),
+		hashTable: make([]uint32, hashTableSize),
+		step:      1,
+		limit:     incompressible,
+	}
+	for ii := range e.hashTable {
+		e.hashTable[ii] = noEntry
+	}
+	return e
+}
+
+func readUint32LE(buf []byte, pos uint32) uint32 {
+	return uint32(buf[pos+3])<<24 | uint32(buf[pos+2])<<16 | uint32(buf[pos+1])<<8 | uint32(buf[pos])
+}
+
+func (e *writer) writeByte(b byte) error {
+	return e.w.WriteByte(b)
+}
+
+// flushLiterals writes the same literal-run/match control byte and literal
+// bytes as encoder.writeLiterals, but to w rather than into a dst slice.
+func (e *writer) flushLiterals(length, mlLen, pos uint32) error {
+
+	ln := length
+
+	var code byte
+	if ln > runMask-1 {
+		code = runMask
+	} else {
+		code = byte(ln)
+	}
+
+	if mlLen > mlMask-1 {
+		if err := e.writeByte((code << mlBits) + byte(mlMask)); err != nil {
+			return err
+		}
+	} else {
+		if err := e.writeByte((code << mlBits) + byte(mlLen)); err != nil {
+			return err
+		}
+	}
+
+	if code == runMask {
+		ln -= runMask
+		for ; ln > 254; ln -= 255 {
+			if err := e.writeByte(255); err != nil {
+				return err
+			}
+		}
+		if err := e.writeByte(byte(ln)); err != nil {
+			return err
+		}
+	}
+
+	if length > 0 {
+		if _, err := e.w.Write(e.buf[pos : pos+length]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *writer) writeBack(back uint16) error {
+	if err := e.writeByte(byte(back)); err != nil {
+		return err
+	}
+	return e.writeByte(byte(back >> 8))
+}
+
+func (e *writer) writeMatchLengthExt(mlLen uint32) error {
+	if mlLen <= mlMask-1 {
+		return nil
+	}
+	mlLen -= mlMask
+	for mlLen > 254 {
+		mlLen -= 255
+		if err := e.writeByte(255); err != nil {
+			return err
+		}
+	}
+	return e.writeByte(byte(mlLen))
+}
+
+// process runs the match loop over buf[pos:] as far as it safely can - it
+// always keeps 4 bytes of lookahead to hash, since a match ending right at
+// the edge of what has been written so far might still extend once more
+// data arrives. final, passed from Close, says no more data is coming, so
+// the trailing literal run should be flushed as-is instead of held back.
+//
+// Like encodeBlockFrom and EncodeHC, matching never reaches the last
+// lastLiterals bytes of what's buffered so far: Write has no way of
+// knowing whether the byte it just appended is the last one the caller
+// will ever send, so a match is never allowed to run right up to the
+// current end of buf - if it did and that happened to also be the end of
+// the whole stream, Close's final flush would emit a trailing literal run
+// of zero, which the reference decoder rejects.
+func (e *writer) process(final bool) error {
+	matchLimit := len(e.buf) - lastLiterals
+	if matchLimit < 0 {
+		matchLimit = 0
+	}
+
+	for {
+		if int(e.pos)+minMatch >= matchLimit {
+			if final {
+				return e.flushLiterals(uint32(len(e.buf))-e.anchor, 0, e.anchor)
+			}
+			return nil
+		}
+
+		sequence := readUint32LE(e.buf, e.pos)
+		hash := (sequence * 2654435761) >> hashShift
+		ref := e.hashTable[hash]
+		e.hashTable[hash] = e.pos
+
+		if ref == noEntry || (e.pos-ref) > maxDistance || readUint32LE(e.buf, ref) != sequence {
+			if e.pos-e.anchor > e.limit {
+				e.limit <<= 1
+				e.step += 1 + (e.step >> 2)
+			}
+			e.pos += e.step
+			continue
+		}
+
+		if e.step > 1 {
+			e.hashTable[hash] = ref
+			e.pos -= e.step - 1
+			e.step = 1
+			continue
+		}
+		e.limit = incompressible
+
+		ln := e.pos - e.anchor
+		back := e.pos - ref
+		anchor := e.anchor
+
+		e.pos += minMatch
+		ref += minMatch
+		e.anchor = e.pos
+
+		for int(e.pos) < matchLimit && e.buf[e.pos] == e.buf[ref] {
+			e.pos++
+			ref++
+		}
+
+		mlLen := e.pos - e.anchor
+
+		if err := e.flushLiterals(ln, mlLen, anchor); err != nil {
+			return err
+		}
+		if err := e.writeBack(uint16(back)); err != nil {
+			return err
+		}
+		if err := e.writeMatchLengthExt(mlLen); err != nil {
+			return err
+		}
+
+		e.anchor = e.pos
+	}
+}
+
+// compact discards the part of buf more than maxDistance bytes before
+// anchor - already flushed output that no future match can ever reach back
+// into - once buf has grown past writerCompactThreshold, invalidating any
+// hash chain entry that pointed into the discarded range.
+func (e *writer) compact() {
+	if len(e.buf) < writerCompactThreshold || e.anchor <= maxDistance {
+		return
+	}
+
+	discard := e.anchor - maxDistance
+	copy(e.buf, e.buf[discard:])
+	e.buf = e.buf[:uint32(len(e.buf))-discard]
+	e.pos -= discard
+	e.anchor -= discard
+
+	for ii := range e.hashTable {
+		if e.hashTable[ii] == noEntry {
+			continue
+		}
+		if e.hashTable[ii] < discard {
+			e.hashTable[ii] = noEntry
+		} else {
+			e.hashTable[ii] -= discard
+		}
+	}
+}
+
+func (e *writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("lz4: write to closed Writer")
+	}
+
+	e.buf = append(e.buf, p...)
+	if err := e.process(false); err != nil {
+		return 0, err
+	}
+	e.compact()
+
+	return len(p), nil
+}
+
+// Close flushes the trailing literal run, if any, and the underlying
+// bufio.Writer. It is safe to call more than once.
+func (e *writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	if err := e.process(true); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
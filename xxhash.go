@@ -0,0 +1,190 @@
+/*
+ * Copyright 2011-2012 Branimir Karadzic. All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ *    1. Redistributions of source code must retain the above copyright notice, this
+ *       list of conditions and the following disclaimer.
+ *
+ *    2. Redistributions in binary form must reproduce the above copyright notice,
+ *       this list of conditions and the following disclaimer in the documentation
+ *       and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY COPYRIGHT HOLDER ``AS IS'' AND ANY EXPRESS OR
+ * IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT
+ * SHALL COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT,
+ * INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+ * PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+ * OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF
+ * THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package lz4
+
+import "encoding/binary"
+
+// xxHash32 constants, as specified by the xxHash reference implementation.
+const (
+	xxh32Prime1 uint32 = 2654435761
+	xxh32Prime2 uint32 = 2246822519
+	xxh32Prime3 uint32 = 3266489917
+	xxh32Prime4 uint32 = 668265263
+	xxh32Prime5 uint32 = 374761393
+)
+
+// xxh32Checksum computes the one-shot xxHash32 digest of data using seed,
+// the checksum used throughout the LZ4 frame format.
+func xxh32Checksum(seed uint32, data []byte) uint32 {
+
+	n := len(data)
+	i := 0
+
+	var h uint32
+	if n >= 16 {
+		v1 := seed + xxh32Prime1 + xxh32Prime2
+		v2 := seed + xxh32Prime2
+		v3 := seed
+		v4 := seed - xxh32Prime1
+
+		for ; i+16 <= n; i += 16 {
+			v1 = xxh32Round(v1, binary.LittleEndian.Uint32(data[i:]))
+			v2 = xxh32Round(v2, binary.LittleEndian.Uint32(data[i+4:]))
+			v3 = xxh32Round(v3, binary.LittleEndian.Uint32(data[i+8:]))
+			v4 = xxh32Round(v4, binary.LittleEndian.Uint32(data[i+12:]))
+		}
+
+		h = rotl32(v1, 1) + rotl32(v2, 7) + rotl32(v3, 12) + rotl32(v4, 18)
+	} else {
+		h = seed + xxh32Prime5
+	}
+
+	h += uint32(n)
+
+	for ; i+4 <= n; i += 4 {
+		h += binary.LittleEndian.Uint32(data[i:]) * xxh32Prime3
+		h = rotl32(h, 17) * xxh32Prime4
+	}
+
+	for ; i < n; i++ {
+		h += uint32(data[i]) * xxh32Prime5
+		h = rotl32(h, 11) * xxh32Prime1
+	}
+
+	h ^= h >> 15
+	h *= xxh32Prime2
+	h ^= h >> 13
+	h *= xxh32Prime3
+	h ^= h >> 16
+
+	return h
+}
+
+func xxh32Round(acc, input uint32) uint32 {
+	acc += input * xxh32Prime2
+	acc = rotl32(acc, 13)
+	acc *= xxh32Prime1
+	return acc
+}
+
+func rotl32(x uint32, r uint) uint32 {
+	return (x << r) | (x >> (32 - r))
+}
+
+// xxh32State computes xxHash32 incrementally across any number of Write
+// calls, needed for the frame content checksum which spans every block
+// written to a FrameWriter regardless of how the caller chunked its Write
+// calls.
+type xxh32State struct {
+	seed           uint32
+	v1, v2, v3, v4 uint32
+	total          uint64
+	mem            [16]byte
+	memSize        int
+}
+
+func newXxh32State(seed uint32) *xxh32State {
+	s := &xxh32State{seed: seed}
+	s.Reset()
+	return s
+}
+
+// Reset returns the state to what it was right after construction, ready
+// to hash a new stream with the same seed.
+func (s *xxh32State) Reset() {
+	s.v1 = s.seed + xxh32Prime1 + xxh32Prime2
+	s.v2 = s.seed + xxh32Prime2
+	s.v3 = s.seed
+	s.v4 = s.seed - xxh32Prime1
+	s.total = 0
+	s.memSize = 0
+}
+
+func (s *xxh32State) Write(data []byte) {
+
+	s.total += uint64(len(data))
+
+	if s.memSize+len(data) < 16 {
+		copy(s.mem[s.memSize:], data)
+		s.memSize += len(data)
+		return
+	}
+
+	if s.memSize > 0 {
+		n := 16 - s.memSize
+		copy(s.mem[s.memSize:], data[:n])
+		s.v1 = xxh32Round(s.v1, binary.LittleEndian.Uint32(s.mem[0:]))
+		s.v2 = xxh32Round(s.v2, binary.LittleEndian.Uint32(s.mem[4:]))
+		s.v3 = xxh32Round(s.v3, binary.LittleEndian.Uint32(s.mem[8:]))
+		s.v4 = xxh32Round(s.v4, binary.LittleEndian.Uint32(s.mem[12:]))
+		data = data[n:]
+		s.memSize = 0
+	}
+
+	for len(data) >= 16 {
+		s.v1 = xxh32Round(s.v1, binary.LittleEndian.Uint32(data[0:]))
+		s.v2 = xxh32Round(s.v2, binary.LittleEndian.Uint32(data[4:]))
+		s.v3 = xxh32Round(s.v3, binary.LittleEndian.Uint32(data[8:]))
+		s.v4 = xxh32Round(s.v4, binary.LittleEndian.Uint32(data[12:]))
+		data = data[16:]
+	}
+
+	if len(data) > 0 {
+		copy(s.mem[:], data)
+		s.memSize = len(data)
+	}
+}
+
+func (s *xxh32State) Sum32() uint32 {
+
+	var h uint32
+	if s.total >= 16 {
+		h = rotl32(s.v1, 1) + rotl32(s.v2, 7) + rotl32(s.v3, 12) + rotl32(s.v4, 18)
+	} else {
+		h = s.seed + xxh32Prime5
+	}
+
+	h += uint32(s.total)
+
+	data := s.mem[:s.memSize]
+	i := 0
+	for ; i+4 <= len(data); i += 4 {
+		h += binary.LittleEndian.Uint32(data[i:]) * xxh32Prime3
+		h = rotl32(h, 17) * xxh32Prime4
+	}
+	for ; i < len(data); i++ {
+		h += uint32(data[i]) * xxh32Prime5
+		h = rotl32(h, 11) * xxh32Prime1
+	}
+
+	h ^= h >> 15
+	h *= xxh32Prime2
+	h ^= h >> 13
+	h *= xxh32Prime3
+	h ^= h >> 16
+
+	return h
+}